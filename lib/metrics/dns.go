@@ -0,0 +1,32 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import "github.com/loadimpact/k6/stats"
+
+// DNS lookup metrics, populated by netext.Dialer's resolver path. They are
+// tagged with name, qtype, server, protocol, rcode and cache, so that a
+// single slow or failing nameserver can be singled out from the dashboard.
+var (
+	DNSLookups        = stats.New("dns_lookups", stats.Counter)
+	DNSLookupFailures = stats.New("dns_lookup_failures", stats.Counter)
+	DNSLookupDuration = stats.New("dns_lookup_duration", stats.Trend, stats.Time)
+)
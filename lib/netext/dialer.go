@@ -22,49 +22,225 @@ package netext
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/loadimpact/resolvent"
+	rescache "github.com/loadimpact/resolvent/querier/cache"
 	resq "github.com/loadimpact/resolvent/querier/network"
 	"github.com/miekg/dns"
+	"github.com/pkg/errors"
 
 	"github.com/loadimpact/k6/lib"
 	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/stats"
 )
 
+// ResolverConfig configures the nameservers a Dialer's lookups query (see
+// lookup and queryNameServers), and how it talks to them. A zero-value ResolverConfig is
+// completed from the host's own resolver configuration (/etc/resolv.conf,
+// /etc/hosts, /etc/nsswitch.conf, or their platform equivalents) by
+// NewDialer.
+type ResolverConfig struct {
+	// Protocol is the DNS transport to use: resolvent.UDP, resolvent.TCP,
+	// resolvent.DoT or resolvent.DoH.
+	Protocol resolvent.Protocol
+	// NameServers and Port identify the nameservers to query for UDP, TCP
+	// and DoT; they are tried according to Strategy, honouring Attempts
+	// and Timeout.
+	NameServers []net.IP
+	Port        uint16
+	// TLS is the configuration (SNI, pinned SPKI, ...) used for DoT.
+	TLS *tls.Config
+	// URL is the "application/dns-message" endpoint used for DoH.
+	URL string
+
+	// Search is the list of domains appended to names, as in the
+	// resolv.conf "search" directive: names with fewer than Ndots dots try
+	// them before the bare name, other names after it.
+	Search []string
+	Ndots  int
+
+	// Timeout and Attempts bound how long queryNameServers waits for each
+	// nameserver, and how many times it cycles through NameServers.
+	Timeout  time.Duration
+	Attempts int
+
+	// Rotate is taken from the resolv.conf "rotate" option: when set,
+	// successive lookups for the same host return its resolved addresses
+	// starting at a different entry instead of always starting at the
+	// first one (see Dialer.rotate); the order in which NameServers are
+	// queried is unaffected. It's a *bool, rather than a bool, so that
+	// mergeResolverConfig can tell an explicit "don't rotate" (false) apart
+	// from "not set" (nil) - unlike Protocol's zero value, false is itself
+	// a meaningful explicit choice here, so it can't double as "not set"
+	// without also overriding a caller who deliberately disabled rotation.
+	Rotate *bool
+
+	// SingleRequest reproduces the resolv.conf "single-request"/
+	// "single-request-reopen" options: resolveAllFamilies queries A then
+	// AAAA one at a time instead of racing them in parallel, working
+	// around nameservers that mishandle two closely-spaced queries from
+	// the same source port.
+	SingleRequest bool
+
+	// Strategy selects how queryNameServers iterates NameServers: try them
+	// in order (resq.Sequential, the zero value and historical k6
+	// behaviour), in a randomised order (resq.Random), or all at once,
+	// taking whichever answers first (resq.ParallelFirstResponse). There's
+	// no resolv.conf equivalent, so mergeResolverConfig never overrides it
+	// with a host value.
+	Strategy resq.Strategy
+
+	// NSSwitchOrder is the "files"/"dns" lookup order taken from
+	// /etc/nsswitch.conf's "hosts" line. It defaults to []string{"files",
+	// "dns"}, matching the historical k6 behaviour of always checking
+	// Hosts before querying NameServers.
+	NSSwitchOrder []string
+
+	// DNSSEC, ClientSubnet and Cookie configure the EDNS0 OPT record
+	// attached to every outgoing UDP/TCP/DoT query - see
+	// resq.EDNSOptions. They have no resolv.conf equivalent, so
+	// mergeResolverConfig never overrides them with a host value.
+	DNSSEC       bool
+	ClientSubnet *net.IPNet
+	Cookie       []byte
+}
+
+const (
+	defaultNdots    = 1
+	defaultAttempts = 2
+	defaultTimeout  = 5 * time.Second
+	defaultPort     = 53
+
+	// defaultCacheMaxConcurrentPerServer bounds how many upstream queries
+	// the resolver cache may have in flight at once for a single
+	// nameserver.
+	defaultCacheMaxConcurrentPerServer = 100
+)
+
+var defaultNSSwitchOrder = []string{"files", "dns"}
+
+// multiServerQuerier is implemented by every querier NewDialer builds: the
+// plain network querier and the caching layer wrapped around it both
+// forward QueryServers, picking among ResolverConfig.NameServers (or,
+// for DoH, the single ResolverConfig.URL endpoint) according to
+// ResolverConfig.Strategy. See queryNameServers.
+type multiServerQuerier interface {
+	resolvent.Querier
+	QueryServers(
+		ctx context.Context,
+		servers []resq.Server,
+		strategy resq.Strategy,
+		perServerTimeout time.Duration,
+		edns resq.EDNSOptions,
+		qname string,
+		qclass uint16,
+		qtype uint16,
+	) (response *dns.Msg, duration time.Duration, used resq.Server, err error)
+}
+
 // Dialer wraps net.Dialer and provides k6 specific functionality -
 // tracing, blacklists and DNS cache and aliases.
 type Dialer struct {
 	net.Dialer
 
-	Resolver  resolvent.Querier
-	Blacklist []*lib.IPNet
-	Hosts     map[string]net.IP
+	Resolver       multiServerQuerier
+	ResolverConfig ResolverConfig
+	Blacklist      []*lib.IPNet
+	Hosts          map[string]net.IP
+
+	// Samples, when non-nil, receives a dnsTrail for every DNS query the
+	// resolver path performs - see recordDNSLookup. A nil channel (the
+	// zero value) silently disables DNS metric collection.
+	Samples chan<- stats.SampleContainer
 
 	BytesRead    int64
 	BytesWritten int64
+
+	rrMu      sync.Mutex
+	rrCursors map[string]uint32
 }
 
-// NewDialer constructs a new Dialer and initializes its cache.
-func NewDialer(dialer net.Dialer, blacklist []*lib.IPNet, hosts map[string]net.IP) (*Dialer, error) {
-	var (
-		q   resolvent.Querier
-		err error
-	)
-	if q, err = resq.New(); err != nil {
+// NewDialer constructs a new Dialer and initializes its cache. Any part of
+// resolverConfig left at its zero value (no NameServers, no Search, ...) is
+// filled in from the host's own resolver configuration; hosts always takes
+// precedence over entries found in /etc/hosts (or its platform equivalent)
+// for the same name. samples may be nil, in which case the Dialer performs
+// lookups as usual but emits no dns_lookup* metric samples.
+func NewDialer(
+	dialer net.Dialer, resolverConfig ResolverConfig, blacklist []*lib.IPNet, hosts map[string]net.IP,
+	samples chan<- stats.SampleContainer,
+) (*Dialer, error) {
+	hostConfig, systemHosts, err := loadHostResolverConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading host resolver configuration")
+	}
+	resolverConfig = mergeResolverConfig(resolverConfig, hostConfig)
+
+	mergedHosts := make(map[string]net.IP, len(systemHosts)+len(hosts))
+	for name, ip := range systemHosts {
+		mergedHosts[name] = ip
+	}
+	for name, ip := range hosts {
+		mergedHosts[name] = ip
+	}
+
+	d := &Dialer{
+		Dialer:         dialer,
+		ResolverConfig: resolverConfig,
+		Blacklist:      blacklist,
+		Hosts:          mergedHosts,
+		Samples:        samples,
+		rrCursors:      make(map[string]uint32),
+	}
+
+	var q resolvent.Querier
+	if resolverConfig.Protocol == resolvent.DoH {
+		// Route DoH lookups through the Dialer itself so that the bytes
+		// they exchange are counted towards BytesRead/BytesWritten like
+		// every other connection the Dialer opens.
+		httpClient := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, dialErr := d.Dialer.DialContext(ctx, network, addr)
+					if dialErr != nil {
+						return nil, dialErr
+					}
+					return &Conn{conn, &d.BytesRead, &d.BytesWritten}, nil
+				},
+			},
+		}
+		if q, err = resq.NewDoH(resolverConfig.URL, httpClient); err != nil {
+			return nil, err
+		}
+	} else {
+		edns := resq.EDNSOptions{
+			DNSSEC:       resolverConfig.DNSSEC,
+			ClientSubnet: resolverConfig.ClientSubnet,
+			Cookie:       resolverConfig.Cookie,
+		}
+		if q, err = resq.New(resolverConfig.TLS, edns); err != nil {
+			return nil, err
+		}
+	}
+
+	// Wrap the querier in a cache so that thousands of VUs hitting the
+	// same hostname don't turn into thousands of duplicate upstream DNS
+	// queries per second; concurrent misses for the same key are
+	// coalesced into a single upstream lookup.
+	cachedQuerier, err := rescache.New(q, defaultCacheMaxConcurrentPerServer, 0)
+	if err != nil {
 		return nil, err
 	}
-	return &Dialer{
-		Dialer:    dialer,
-		Resolver:  q,
-		Blacklist: blacklist,
-		Hosts:     hosts,
-	}, nil
+	d.Resolver = cachedQuerier
+	return d, nil
 }
 
 // BlackListedIPError is an error that is returned when a given IP is blacklisted
@@ -77,88 +253,30 @@ func (b BlackListedIPError) Error() string {
 	return fmt.Sprintf("IP (%s) is in a blacklisted range (%s)", b.ip, b.net)
 }
 
-// DialContext wraps the net.Dialer.DialContext and handles the k6 specifics
+// DialContext wraps the net.Dialer.DialContext and handles the k6 specifics:
+// resolving addr's host (via Hosts/DNS per ResolverConfig.NSSwitchOrder),
+// discarding blacklisted candidates, and racing the remaining IPv6/IPv4
+// candidates with Happy Eyeballs (RFC 8305).
 func (d *Dialer) DialContext(ctx context.Context, proto, addr string) (net.Conn, error) {
-	address, err := d.checkAndResolveAddress(ctx, addr, d.Resolver)
-	if err != nil {
-		return nil, err
-	}
-
-	var conn net.Conn
-	conn, err = d.Dialer.DialContext(ctx, proto, address)
-	if err != nil {
-		return nil, err
-	}
-	conn = &Conn{conn, &d.BytesRead, &d.BytesWritten}
-	return conn, err
-}
-
-func (d *Dialer) checkAndResolveAddress(
-	ctx context.Context, addr string, resolver resolvent.Querier,
-) (string, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return "", err
-	}
-
-	ip := net.ParseIP(host)
-	if ip == nil {
-		// It's not an IP address, so lookup the hostname in the Hosts
-		// option before trying to resolve DNS.
-		var ok bool
-		ip, ok = d.Hosts[host]
-		if !ok {
-			var dnsErr error
-			ips, dnsErr := d.resolve(ctx, host)
-			if dnsErr != nil {
-				return "", dnsErr
-			}
-			// TODO: Round-robin?
-			ip = ips[rand.Intn(len(ips))]
-		}
+		return nil, err
 	}
 
-	for _, ipnet := range d.Blacklist {
-		if (*net.IPNet)(ipnet).Contains(ip) {
-			return "", BlackListedIPError{ip: ip, net: ipnet}
+	var candidates []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		candidates = []net.IP{ip}
+	} else {
+		if candidates, err = d.lookup(ctx, host); err != nil {
+			return nil, err
 		}
 	}
 
-	return net.JoinHostPort(ip.String(), port), nil
-}
-
-func (d *Dialer) resolve(ctx context.Context, host string) ([]net.IP, error) {
-	// TODO: Check /etc/{nsswitch.conf,hosts} first?
-	// TODO: Handle IPv6 AAAA records, CNAMEs...
-	response, _, err := d.Resolver.Query(
-		ctx,
-		resolvent.TCP,
-		net.IPv4zero,
-		// TODO: Check /etc/resolv.conf ? See miekg/dns.ClientConfigFromFile()
-		net.ParseIP("127.0.0.1"),
-		53,
-		host,
-		dns.ClassINET,
-		dns.TypeA,
-	)
-	if err != nil {
+	if candidates, err = d.filterBlacklisted(candidates); err != nil {
 		return nil, err
 	}
-	if len(response.Answer) == 0 {
-		return nil, fmt.Errorf("DNS lookup for '%s' returned zero entries", host)
-	}
-
-	ips := make([]net.IP, 0, len(response.Answer))
-	for _, ans := range response.Answer {
-		switch a := ans.(type) {
-		case *dns.A:
-			ips = append(ips, a.A)
-		case *dns.AAAA:
-			ips = append(ips, a.AAAA)
-		}
-	}
 
-	return ips, nil
+	return d.dialHappyEyeballs(ctx, proto, port, candidates)
 }
 
 // GetTrail creates a new NetTrail instance with the Dialer
@@ -213,7 +331,8 @@ func (d *Dialer) GetTrail(
 }
 
 // NetTrail contains information about the exchanged data size and length of a
-// series of connections from a particular netext.Dialer
+// series of connections from a particular netext.Dialer. DNS lookup metrics
+// are reported separately, as they happen: see dnsTrail.
 type NetTrail struct {
 	BytesRead     int64
 	BytesWritten  int64
@@ -0,0 +1,117 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	resq "github.com/loadimpact/resolvent/querier/network"
+	"github.com/miekg/dns"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+)
+
+// dnsTrail reports the outcome of a single DNS query: how long it took and
+// whether it resolved. Unlike NetTrail - which a VU accumulates over an
+// iteration and collects on demand via GetTrail - a dnsTrail is pushed to
+// Dialer.Samples as soon as the query completes, the same way the HTTP
+// module pushes its own request samples.
+type dnsTrail struct {
+	Tags    *stats.SampleTags
+	Samples []stats.Sample
+}
+
+// Ensure that interfaces are implemented correctly
+var _ stats.ConnectedSampleContainer = &dnsTrail{}
+
+// GetSamples implements the stats.SampleContainer interface.
+func (dt *dnsTrail) GetSamples() []stats.Sample {
+	return dt.Samples
+}
+
+// GetTags implements the stats.ConnectedSampleContainer interface.
+func (dt *dnsTrail) GetTags() *stats.SampleTags {
+	return dt.Tags
+}
+
+// GetTime implements the stats.ConnectedSampleContainer interface.
+func (dt *dnsTrail) GetTime() time.Time {
+	return dt.Samples[0].Time
+}
+
+// recordDNSLookup pushes dns_lookups and dns_lookup_duration samples (and,
+// on failure, dns_lookup_failures) for a single query to a single
+// nameserver. It's a no-op when Samples hasn't been wired up, which keeps
+// every direct construction of a Dialer working without a stats collector.
+func (d *Dialer) recordDNSLookup(
+	name string,
+	qtype uint16,
+	server resq.Server,
+	response *dns.Msg,
+	cacheHit bool,
+	duration time.Duration,
+	queryErr error,
+) {
+	if d.Samples == nil {
+		return
+	}
+
+	rcode := "error"
+	if response != nil {
+		rcode = dns.RcodeToString[response.Rcode]
+	}
+	cache := "miss"
+	if cacheHit {
+		cache = "hit"
+	}
+
+	// DoH has no per-nameserver address/port - dohQuerier.Query ignores
+	// both entirely - so tag the sample with the endpoint queries actually
+	// went to instead of server's unused (zero-value) Remote/Port.
+	serverTag := d.ResolverConfig.URL
+	if server.Protocol != resolvent.DoH {
+		serverTag = net.JoinHostPort(server.Remote.String(), strconv.Itoa(int(server.Port)))
+	}
+
+	tags := stats.NewSampleTags(map[string]string{
+		"name":     name,
+		"qtype":    dns.TypeToString[qtype],
+		"server":   serverTag,
+		"protocol": server.Protocol.String(),
+		"rcode":    rcode,
+		"cache":    cache,
+	})
+
+	now := time.Now()
+	samples := []stats.Sample{
+		{Time: now, Metric: metrics.DNSLookups, Value: 1, Tags: tags},
+		{Time: now, Metric: metrics.DNSLookupDuration, Value: stats.D(duration), Tags: tags},
+	}
+	if queryErr != nil {
+		samples = append(samples, stats.Sample{Time: now, Metric: metrics.DNSLookupFailures, Value: 1, Tags: tags})
+	}
+
+	d.Samples <- &dnsTrail{Tags: tags, Samples: samples}
+}
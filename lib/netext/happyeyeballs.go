@@ -0,0 +1,165 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+)
+
+// happyEyeballsResolutionDelay is RFC 8305's recommended delay between
+// starting the IPv6 connection attempt and, if it hasn't won yet, starting
+// the IPv4 one alongside it.
+const happyEyeballsResolutionDelay = 250 * time.Millisecond
+
+// filterBlacklisted drops every blacklisted address from ips. It only
+// returns an error - the blacklist violation for the first match found -
+// when nothing usable is left, so that a single blacklisted record in an
+// otherwise healthy RRset doesn't fail the whole dial.
+func (d *Dialer) filterBlacklisted(ips []net.IP) ([]net.IP, error) {
+	allowed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ipnet := d.blacklistedBy(ip); ipnet == nil {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 && len(ips) > 0 {
+		ipnet := d.blacklistedBy(ips[0])
+		return nil, BlackListedIPError{ip: ips[0], net: ipnet}
+	}
+	return allowed, nil
+}
+
+func (d *Dialer) blacklistedBy(ip net.IP) *lib.IPNet {
+	for _, ipnet := range d.Blacklist {
+		if (*net.IPNet)(ipnet).Contains(ip) {
+			return ipnet
+		}
+	}
+	return nil
+}
+
+// dialHappyEyeballs dials candidates using RFC 8305 Happy Eyeballs v2: the
+// first IPv6 candidate is dialed immediately, the first IPv4 candidate is
+// dialed happyEyeballsResolutionDelay later if IPv6 hasn't already
+// succeeded (or as soon as every IPv6 candidate has failed, if that comes
+// sooner), and whichever connects first wins - the other attempt is
+// cancelled. Families with no candidates are skipped; a single-family
+// candidate list is dialed directly, in order, with no race.
+func (d *Dialer) dialHappyEyeballs(
+	ctx context.Context, proto, port string, candidates []net.IP,
+) (net.Conn, error) {
+	v6, v4 := splitByFamily(candidates)
+	if len(v6) == 0 {
+		return d.dialFirst(ctx, proto, port, v4)
+	}
+	if len(v4) == 0 {
+		return d.dialFirst(ctx, proto, port, v6)
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	v6Result := make(chan attempt, 1)
+	go func() {
+		conn, err := d.dialFirst(raceCtx, proto, port, v6)
+		v6Result <- attempt{conn, err}
+	}()
+
+	var v4Result chan attempt
+	startV4 := func() {
+		if v4Result != nil {
+			return
+		}
+		v4Result = make(chan attempt, 1)
+		go func() {
+			conn, err := d.dialFirst(raceCtx, proto, port, v4)
+			v4Result <- attempt{conn, err}
+		}()
+	}
+
+	timer := time.NewTimer(happyEyeballsResolutionDelay)
+	defer timer.Stop()
+
+	var v6Done, v4Done bool
+	var lastErr error
+	for !v6Done || !v4Done {
+		select {
+		case res := <-v6Result:
+			v6Done = true
+			if res.err == nil {
+				cancel()
+				return res.conn, nil
+			}
+			lastErr = res.err
+			startV4()
+		case res := <-v4Result:
+			v4Done = true
+			if res.err == nil {
+				cancel()
+				return res.conn, nil
+			}
+			lastErr = res.err
+		case <-timer.C:
+			startV4()
+		}
+	}
+	return nil, lastErr
+}
+
+// dialFirst tries candidates in order and returns the first successful
+// connection.
+func (d *Dialer) dialFirst(ctx context.Context, proto, port string, candidates []net.IP) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range candidates {
+		conn, err := d.Dialer.DialContext(ctx, proto, net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return &Conn{conn, &d.BytesRead, &d.BytesWritten}, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("dial %s: no addresses to dial", proto)
+	}
+	return nil, lastErr
+}
+
+// splitByFamily splits ips into IPv6 and IPv4 addresses, preserving their
+// relative order within each group.
+func splitByFamily(ips []net.IP) (v6, v4 []net.IP) {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	return v6, v4
+}
@@ -0,0 +1,79 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDialHappyEyeballsFallsBackToIPv4 listens only on the IPv4 loopback
+// address and dials a candidate list containing both the IPv6 and IPv4
+// loopback on that same port. The IPv6 attempt fails immediately
+// (nothing is listening there), which must make dialHappyEyeballs start
+// the IPv4 attempt right away rather than waiting out the full
+// happyEyeballsResolutionDelay.
+func TestDialHappyEyeballsFallsBackToIPv4(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, portStr, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort: %v", err)
+	}
+
+	d := &Dialer{}
+	candidates := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+
+	start := time.Now()
+	conn, err := d.dialHappyEyeballs(context.Background(), "tcp", portStr, candidates)
+	if err != nil {
+		t.Fatalf("dialHappyEyeballs: %v", err)
+	}
+	defer conn.Close()
+
+	if elapsed := time.Since(start); elapsed >= happyEyeballsResolutionDelay {
+		t.Errorf("dialHappyEyeballs took %s, expected it to fall back to IPv4 well before the %s resolution delay", elapsed, happyEyeballsResolutionDelay)
+	}
+}
+
+func TestSplitByFamily(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.2")}
+	v6, v4 := splitByFamily(ips)
+	if len(v6) != 1 || len(v4) != 2 {
+		t.Fatalf("splitByFamily(%v) = v6=%v v4=%v, want 1 IPv6 and 2 IPv4 addresses", ips, v6, v4)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/loadimpact/resolvent"
+)
+
+// loadHostResolverConfig reads the host's own resolver configuration:
+// nameservers, search domains and options from its resolv.conf equivalent,
+// name-to-address mappings from its hosts file, and "files"/"dns" ordering
+// from its nsswitch.conf equivalent (where one exists). Any of the three
+// sources being absent is not an error; the corresponding fields are left
+// at their zero value, except NSSwitchOrder, which falls back to the
+// files-then-dns default.
+func loadHostResolverConfig() (ResolverConfig, map[string]net.IP, error) {
+	config, err := loadPlatformResolverConfig()
+	if err != nil {
+		return ResolverConfig{}, nil, err
+	}
+
+	hosts, err := loadHostsFile(hostsFilePath)
+	if err != nil {
+		return ResolverConfig{}, nil, err
+	}
+
+	order, err := loadNSSwitchOrder()
+	if err != nil {
+		return ResolverConfig{}, nil, err
+	}
+	config.NSSwitchOrder = order
+
+	return config, hosts, nil
+}
+
+// mergeResolverConfig overlays host on top of explicit, keeping every
+// explicit field that was actually set and falling back to the host's
+// value otherwise.
+//
+// Protocol's fallback is independent of NameServers: resolvent.UDP is both
+// the zero value and a valid explicit choice, so it's the only Protocol a
+// caller who didn't set the field can have, and is therefore what "not set"
+// means here. Folding this into the NameServers check would silently
+// downgrade an explicit DoH config (which has no NameServers at all, only a
+// URL) back to the host's UDP resolver.
+func mergeResolverConfig(explicit, host ResolverConfig) ResolverConfig {
+	merged := explicit
+	if merged.Protocol == resolvent.UDP {
+		merged.Protocol = host.Protocol
+	}
+	if len(merged.NameServers) == 0 {
+		merged.NameServers = host.NameServers
+	}
+	if merged.Port == 0 {
+		merged.Port = host.Port
+	}
+	if len(merged.Search) == 0 {
+		merged.Search = host.Search
+	}
+	if merged.Ndots == 0 {
+		merged.Ndots = host.Ndots
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = host.Timeout
+	}
+	if merged.Attempts == 0 {
+		merged.Attempts = host.Attempts
+	}
+	if merged.Rotate == nil {
+		merged.Rotate = host.Rotate
+	}
+	if !merged.SingleRequest {
+		merged.SingleRequest = host.SingleRequest
+	}
+	if len(merged.NSSwitchOrder) == 0 {
+		merged.NSSwitchOrder = host.NSSwitchOrder
+	}
+	return merged
+}
+
+// loadHostsFile parses a hosts(5) formatted file into a name -> address
+// map. A missing file yields an empty map rather than an error, since not
+// every platform ships one.
+func loadHostsFile(path string) (map[string]net.IP, error) {
+	hosts := make(map[string]net.IP)
+
+	file, err := os.Open(path) // nolint:gosec
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hosts, nil
+		}
+		return nil, err
+	}
+	defer file.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if _, exists := hosts[name]; !exists {
+				hosts[name] = ip
+			}
+		}
+	}
+	return hosts, scanner.Err()
+}
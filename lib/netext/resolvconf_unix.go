@@ -0,0 +1,148 @@
+// +build !windows
+
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConfPath   = "/etc/resolv.conf"
+	hostsFilePath    = "/etc/hosts"
+	nsswitchConfPath = "/etc/nsswitch.conf"
+)
+
+// loadPlatformResolverConfig parses /etc/resolv.conf the way the system
+// resolver does, using miekg/dns's own resolv.conf parser for the
+// nameservers/search/ndots/timeout/attempts it already understands, and a
+// small extra scan for the "rotate" and "single-request" options that
+// dns.ClientConfigFromFile doesn't surface.
+func loadPlatformResolverConfig() (ResolverConfig, error) {
+	cc, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ResolverConfig{}, nil
+		}
+		return ResolverConfig{}, err
+	}
+
+	config := ResolverConfig{
+		Protocol: resolvent.UDP,
+		Search:   cc.Search,
+		Ndots:    cc.Ndots,
+		Attempts: cc.Attempts,
+		Timeout:  time.Duration(cc.Timeout) * time.Second,
+	}
+	for _, server := range cc.Servers {
+		if ip := net.ParseIP(server); ip != nil {
+			config.NameServers = append(config.NameServers, ip)
+		}
+	}
+	if port, portErr := strconv.Atoi(cc.Port); portErr == nil {
+		config.Port = uint16(port)
+	}
+
+	rotate, singleRequest, err := scanResolvConfOptions(resolvConfPath)
+	if err != nil {
+		return ResolverConfig{}, err
+	}
+	config.Rotate = &rotate
+	config.SingleRequest = singleRequest
+
+	return config, nil
+}
+
+// scanResolvConfOptions looks for a resolv.conf "options" line and reports
+// whether "rotate" and "single-request" were requested.
+func scanResolvConfOptions(path string) (rotate, singleRequest bool, err error) {
+	file, err := os.Open(path) // nolint:gosec
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	defer file.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "options" {
+			continue
+		}
+		for _, option := range fields[1:] {
+			switch option {
+			case "rotate":
+				rotate = true
+			case "single-request", "single-request-reopen":
+				singleRequest = true
+			}
+		}
+	}
+	return rotate, singleRequest, scanner.Err()
+}
+
+// loadNSSwitchOrder parses the "hosts:" line of /etc/nsswitch.conf, keeping
+// only the "files" and "dns" sources (in the order they're listed) and
+// discarding sources such as "mdns4_minimal" or "myhostname" that this
+// Dialer doesn't implement. A missing file, or one without a "hosts" line,
+// yields the historical files-then-dns default.
+func loadNSSwitchOrder() ([]string, error) {
+	file, err := os.Open(nsswitchConfPath) // nolint:gosec
+	if err != nil {
+		if os.IsNotExist(err) {
+			return append([]string(nil), defaultNSSwitchOrder...), nil
+		}
+		return nil, err
+	}
+	defer file.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "hosts:") {
+			continue
+		}
+		var order []string
+		for _, source := range strings.Fields(strings.TrimPrefix(line, "hosts:")) {
+			if source == "files" || source == "dns" {
+				order = append(order, source)
+			}
+		}
+		if len(order) > 0 {
+			return order, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), defaultNSSwitchOrder...), nil
+}
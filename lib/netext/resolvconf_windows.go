@@ -0,0 +1,77 @@
+// +build windows
+
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+
+	"github.com/loadimpact/resolvent"
+)
+
+const hostsFilePath = `C:\Windows\System32\drivers\etc\hosts`
+
+// loadPlatformResolverConfig reads the system-wide nameserver list from the
+// registry, the same place ipconfig /all and the Windows resolver read it
+// from. A statically configured "NameServer" takes precedence over the one
+// handed out by DHCP.
+func loadPlatformResolverConfig() (ResolverConfig, error) {
+	key, err := registry.OpenKey(
+		registry.LOCAL_MACHINE,
+		`SYSTEM\CurrentControlSet\Services\Tcpip\Parameters`,
+		registry.QUERY_VALUE,
+	)
+	if err != nil {
+		return ResolverConfig{}, nil
+	}
+	defer key.Close() // nolint:errcheck
+
+	value, _, err := key.GetStringValue("NameServer")
+	if err != nil || value == "" {
+		value, _, err = key.GetStringValue("DhcpNameServer")
+	}
+	if err != nil || value == "" {
+		return ResolverConfig{}, nil
+	}
+
+	// Windows has no rotate-equivalent option, so host config explicitly
+	// says "don't rotate" rather than leaving Rotate nil - nil would mean
+	// "host doesn't know", which isn't true here.
+	noRotate := false
+	config := ResolverConfig{Protocol: resolvent.UDP, Rotate: &noRotate}
+	for _, server := range strings.Fields(value) {
+		if ip := net.ParseIP(server); ip != nil {
+			config.NameServers = append(config.NameServers, ip)
+		}
+	}
+	return config, nil
+}
+
+// loadNSSwitchOrder reproduces the Windows resolver's fixed lookup order:
+// there is no nsswitch.conf equivalent, and the hosts file is always
+// checked before DNS.
+func loadNSSwitchOrder() ([]string, error) {
+	return append([]string(nil), defaultNSSwitchOrder...), nil
+}
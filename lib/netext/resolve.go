@@ -0,0 +1,393 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/loadimpact/resolvent"
+	resq "github.com/loadimpact/resolvent/querier/network"
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChainDepth bounds how many CNAME hops resolveType follows before
+// giving up, guarding against both pathological chains and loops that slip
+// past the visited-owner-name check.
+const maxCNAMEChainDepth = 8
+
+// lookup resolves host, honouring the "files"/"dns" order from
+// /etc/nsswitch.conf (ResolverConfig.NSSwitchOrder).
+func (d *Dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	order := d.ResolverConfig.NSSwitchOrder
+	if len(order) == 0 {
+		order = defaultNSSwitchOrder
+	}
+
+	for _, source := range order {
+		switch source {
+		case "files":
+			if ip, ok := d.Hosts[host]; ok {
+				return []net.IP{ip}, nil
+			}
+		case "dns":
+			ips, err := d.resolveHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			if len(ips) > 0 {
+				return ips, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("lookup %s: no such host", host)
+}
+
+// resolveHost returns every A/AAAA address for host, sorted by address
+// preference per (a practical subset of) RFC 6724 and, when
+// ResolverConfig.Rotate is set, rotated through a per-host round-robin
+// cursor so that successive VU iterations spread across the whole RRset
+// instead of hammering a single address.
+func (d *Dialer) resolveHost(ctx context.Context, host string) ([]net.IP, error) {
+	var lastErr error
+	for _, name := range d.searchNames(host) {
+		ips, err := d.resolveAllFamilies(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(ips) > 0 {
+			return d.rotate(host, sortByAddressPreference(ips)), nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("DNS lookup for '%s' returned zero entries", host)
+	}
+	return nil, lastErr
+}
+
+// searchNames expands host into the names to try, applying the
+// ResolverConfig.Search list the way resolv.conf's "ndots" option does:
+// names with fewer dots than Ndots try the search list (in order) before
+// falling back to the bare name; names with enough dots try the bare name
+// first.
+func (d *Dialer) searchNames(host string) []string {
+	search := d.ResolverConfig.Search
+	if len(search) == 0 || strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+
+	ndots := d.ResolverConfig.Ndots
+	if ndots <= 0 {
+		ndots = defaultNdots
+	}
+
+	names := make([]string, 0, len(search)+1)
+	if strings.Count(host, ".") >= ndots {
+		names = append(names, host)
+	}
+	for _, domain := range search {
+		names = append(names, host+"."+strings.TrimSuffix(domain, "."))
+	}
+	if len(names) == 0 || names[0] != host {
+		names = append(names, host)
+	}
+	return names
+}
+
+// resolveAllFamilies issues the A and AAAA lookups for name and merges
+// their results, only failing if both come back empty-handed. It races
+// them in parallel, unless ResolverConfig.SingleRequest (resolv.conf's
+// "single-request"/"single-request-reopen" options) asks for them to be
+// serialised instead.
+func (d *Dialer) resolveAllFamilies(ctx context.Context, name string) ([]net.IP, error) {
+	var a, aaaa struct {
+		ips []net.IP
+		err error
+	}
+
+	if d.ResolverConfig.SingleRequest {
+		a.ips, a.err = d.resolveType(ctx, name, dns.TypeA, nil)
+		aaaa.ips, aaaa.err = d.resolveType(ctx, name, dns.TypeAAAA, nil)
+	} else {
+		type familyResult struct {
+			ips []net.IP
+			err error
+		}
+		aCh := make(chan familyResult, 1)
+		aaaaCh := make(chan familyResult, 1)
+
+		go func() {
+			ips, err := d.resolveType(ctx, name, dns.TypeA, nil)
+			aCh <- familyResult{ips, err}
+		}()
+		go func() {
+			ips, err := d.resolveType(ctx, name, dns.TypeAAAA, nil)
+			aaaaCh <- familyResult{ips, err}
+		}()
+		aRes, aaaaRes := <-aCh, <-aaaaCh
+		a.ips, a.err = aRes.ips, aRes.err
+		aaaa.ips, aaaa.err = aaaaRes.ips, aaaaRes.err
+	}
+
+	ips := make([]net.IP, 0, len(a.ips)+len(aaaa.ips))
+	ips = append(ips, a.ips...)
+	ips = append(ips, aaaa.ips...)
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+	return nil, aaaa.err
+}
+
+// resolveType queries for name's qtype records, following any CNAME chain
+// returned in place of a direct answer, up to maxCNAMEChainDepth hops.
+// visited tracks owner names already queried in this chain so that a loop
+// (A CNAME B, B CNAME A) is reported as an error instead of recursing
+// forever.
+func (d *Dialer) resolveType(
+	ctx context.Context, name string, qtype uint16, visited map[string]struct{},
+) ([]net.IP, error) {
+	owner := dns.Fqdn(name)
+	if visited == nil {
+		visited = make(map[string]struct{})
+	}
+	if _, seen := visited[owner]; seen {
+		return nil, fmt.Errorf("CNAME loop detected resolving %s", name)
+	}
+	if len(visited) > maxCNAMEChainDepth {
+		return nil, fmt.Errorf("CNAME chain for %s exceeds maximum depth of %d", name, maxCNAMEChainDepth)
+	}
+	visited[owner] = struct{}{}
+
+	response, err := d.queryNameServers(ctx, owner, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	var target string
+	for _, ans := range response.Answer {
+		switch rr := ans.(type) {
+		case *dns.A:
+			if qtype == dns.TypeA {
+				ips = append(ips, rr.A)
+			}
+		case *dns.AAAA:
+			if qtype == dns.TypeAAAA {
+				ips = append(ips, rr.AAAA)
+			}
+		case *dns.CNAME:
+			target = rr.Target
+		}
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	if target != "" {
+		return d.resolveType(ctx, target, qtype, visited)
+	}
+	return nil, nil
+}
+
+// queryNameServers queries ResolverConfig.NameServers for name's qtype
+// records, according to ResolverConfig.Strategy, cycling through the whole
+// list up to Attempts times and bounding each server with Timeout.
+func (d *Dialer) queryNameServers(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	servers := d.candidateServers()
+	timeout := d.ResolverConfig.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	attempts := d.ResolverConfig.Attempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	edns := resq.EDNSOptions{
+		DNSSEC:       d.ResolverConfig.DNSSEC,
+		ClientSubnet: d.ResolverConfig.ClientSubnet,
+		Cookie:       d.ResolverConfig.Cookie,
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		response, duration, server, err := d.Resolver.QueryServers(
+			ctx, servers, d.ResolverConfig.Strategy, timeout, edns, name, dns.ClassINET, qtype,
+		)
+		// The cache querier returns a zero duration both for a fresh cache
+		// hit and for a singleflight follower - in both cases this call
+		// performed no upstream exchange of its own, which is what the
+		// "cache" tag means.
+		d.recordDNSLookup(name, qtype, server, response, err == nil && duration == 0, duration, err)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(response.Answer) == 0 {
+			lastErr = fmt.Errorf("DNS lookup for '%s' returned zero entries", name)
+			continue
+		}
+		return response, nil
+	}
+	return nil, lastErr
+}
+
+// candidateServers builds the Server list queryNameServers asks, from
+// ResolverConfig.NameServers/Port/TLS. DoH has no equivalent notion of a
+// nameserver list - it always talks to ResolverConfig.URL instead - so a
+// single placeholder Server carrying just the protocol stands in for it;
+// recordDNSLookup tags DoH samples from the URL rather than this Server's
+// (unused) address/port.
+func (d *Dialer) candidateServers() []resq.Server {
+	if d.ResolverConfig.Protocol == resolvent.DoH {
+		return []resq.Server{{Protocol: resolvent.DoH}}
+	}
+
+	nameServers := d.ResolverConfig.NameServers
+	if len(nameServers) == 0 {
+		nameServers = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	port := d.ResolverConfig.Port
+	if port == 0 {
+		port = defaultPort
+	}
+
+	servers := make([]resq.Server, len(nameServers))
+	for i, ns := range nameServers {
+		servers[i] = resq.Server{
+			Protocol: d.ResolverConfig.Protocol,
+			Local:    net.IPv4zero,
+			Remote:   ns,
+			Port:     port,
+			TLS:      d.ResolverConfig.TLS,
+		}
+	}
+	return servers
+}
+
+// rotate implements resolv.conf's "rotate" option: when set, it returns ips
+// rotated by a per-host counter that advances on every call, so that
+// successive lookups for the same host start at a different entry instead
+// of always preferring the first one. Without it, ips is returned as-is,
+// matching the standard resolver behaviour of always trying addresses in
+// the order the nameserver (or sortByAddressPreference) returned them.
+func (d *Dialer) rotate(host string, ips []net.IP) []net.IP {
+	if d.ResolverConfig.Rotate == nil || !*d.ResolverConfig.Rotate || len(ips) <= 1 {
+		return ips
+	}
+
+	d.rrMu.Lock()
+	cursor := d.rrCursors[host]
+	d.rrCursors[host] = cursor + 1
+	d.rrMu.Unlock()
+
+	start := int(cursor) % len(ips)
+	if start == 0 {
+		return ips
+	}
+	rotated := make([]net.IP, len(ips))
+	n := copy(rotated, ips[start:])
+	copy(rotated[n:], ips[:start])
+	return rotated
+}
+
+// addressPolicy is one entry of the RFC 6724 section 2.1 default policy
+// table: prefix is matched against a candidate address, precedence ranks
+// address types against each other (higher is preferred) and label groups
+// same-precedence source/destination pairs.
+type addressPolicy struct {
+	prefix     *net.IPNet
+	precedence int
+	label      int
+}
+
+// defaultPolicyTable is a practical subset of RFC 6724's default policy
+// table: it distinguishes loopback, native IPv6, IPv4-mapped IPv6/IPv4, and
+// unique-local addresses, which covers the address shapes k6 scripts
+// actually see in the wild. It omits 6to4, Teredo and the 6bone-era
+// entries, and - lacking a notion of "the source address we'd use" -
+// omits every RFC 6724 rule that compares against one (rules 2, 5, 5.5
+// and 9); this is address-only precedence/scope ordering, not the full
+// algorithm.
+var defaultPolicyTable = []addressPolicy{
+	{mustParseCIDR("::1/128"), 50, 0},
+	{mustParseCIDR("::ffff:0:0/96"), 35, 4},
+	{mustParseCIDR("fc00::/7"), 3, 13},
+	{mustParseCIDR("::/0"), 40, 1},
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipnet
+}
+
+func policyFor(ip net.IP) addressPolicy {
+	candidate := ip.To16()
+	for _, policy := range defaultPolicyTable {
+		if policy.prefix.Contains(candidate) {
+			return policy
+		}
+	}
+	return addressPolicy{precedence: 0, label: 0}
+}
+
+// scope approximates RFC 4007 address scope: smaller is "closer" (link,
+// site) and larger is global. It only separates loopback, link-local and
+// private addresses from globally routable ones, which is all the rule 8
+// tie-break in sortByAddressPreference needs.
+func scope(ip net.IP) int {
+	switch {
+	case ip.IsLoopback():
+		return 0
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return 2
+	case ip.IsPrivate():
+		return 5
+	default:
+		return 14 // global
+	}
+}
+
+// sortByAddressPreference orders ips the way an RFC 6724-aware resolver
+// would: higher policy-table precedence first, ties broken by preferring
+// smaller scope (RFC 6724 rule 8). See defaultPolicyTable for the rules
+// this does and does not implement.
+func sortByAddressPreference(ips []net.IP) []net.IP {
+	sorted := make([]net.IP, len(ips))
+	copy(sorted, ips)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi, pj := policyFor(sorted[i]), policyFor(sorted[j])
+		if pi.precedence != pj.precedence {
+			return pi.precedence > pj.precedence
+		}
+		return scope(sorted[i]) < scope(sorted[j])
+	})
+	return sorted
+}
@@ -0,0 +1,104 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	resq "github.com/loadimpact/resolvent/querier/network"
+	"github.com/miekg/dns"
+)
+
+func newTestDialer(resolver multiServerQuerier, rotate bool) *Dialer {
+	return &Dialer{
+		Resolver: resolver,
+		ResolverConfig: ResolverConfig{
+			NameServers: []net.IP{net.ParseIP("127.0.0.1")},
+			Timeout:     time.Second,
+			Attempts:    1,
+			Rotate:      &rotate,
+		},
+		rrCursors: make(map[string]uint32),
+	}
+}
+
+func TestRotateHonorsConfigFlag(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}
+
+	d := newTestDialer(nil, false)
+	first := d.rotate("host", ips)
+	second := d.rotate("host", ips)
+	if !first[0].Equal(ips[0]) || !second[0].Equal(ips[0]) {
+		t.Errorf("Rotate: false must leave address order unchanged, got %v then %v", first, second)
+	}
+
+	d = newTestDialer(nil, true)
+	first = d.rotate("host", ips)
+	second = d.rotate("host", ips)
+	if first[0].Equal(second[0]) {
+		t.Errorf("Rotate: true must advance the start address on each call, got %v then %v", first, second)
+	}
+}
+
+// cnameLoopQuerier answers every query for "b.example.com." with a CNAME to
+// "a.example.com." and every other query (including "a.example.com.") with
+// a CNAME to "b.example.com.", regardless of qtype.
+type cnameLoopQuerier struct{}
+
+func (cnameLoopQuerier) Query(
+	ctx context.Context, protocol resolvent.Protocol, local, address net.IP, port uint16,
+	qname string, qclass, qtype uint16,
+) (*dns.Msg, time.Duration, error) {
+	target := "b.example.com."
+	if qname == "b.example.com." {
+		target = "a.example.com."
+	}
+	response := new(dns.Msg)
+	response.Answer = []dns.RR{&dns.CNAME{
+		Hdr:    dns.RR_Header{Name: dns.Fqdn(qname), Rrtype: dns.TypeCNAME, Class: qclass},
+		Target: target,
+	}}
+	return response, time.Millisecond, nil
+}
+
+func (q cnameLoopQuerier) QueryServers(
+	ctx context.Context, servers []resq.Server, strategy resq.Strategy, perServerTimeout time.Duration,
+	edns resq.EDNSOptions, qname string, qclass, qtype uint16,
+) (*dns.Msg, time.Duration, resq.Server, error) {
+	response, duration, err := q.Query(ctx, resolvent.UDP, nil, nil, 0, qname, qclass, qtype)
+	var used resq.Server
+	if len(servers) > 0 {
+		used = servers[0]
+	}
+	return response, duration, used, err
+}
+
+func TestResolveTypeDetectsCNAMELoop(t *testing.T) {
+	d := newTestDialer(cnameLoopQuerier{}, false)
+	_, err := d.resolveType(context.Background(), "a.example.com.", dns.TypeA, nil)
+	if err == nil {
+		t.Fatal("resolveType: expected a CNAME loop error, got nil")
+	}
+}
@@ -2,7 +2,10 @@ package internal
 
 import "github.com/miekg/dns"
 
-// Distributor is a query result distributor.
+// Distributor hands a single query result to every interested caller. Each
+// Distributor is good for exactly one Distribute call; Receive may be
+// called any number of times, before or after it, and always returns that
+// one result.
 type Distributor interface {
 	Distribute(response *dns.Msg, err error)
 	Receive() (response *dns.Msg, err error)
@@ -14,28 +17,23 @@ type result struct {
 }
 
 type distributor struct {
-	semaphore chan chan result
+	done   chan struct{}
+	result result
 }
 
 func NewDistributor() *distributor {
-	return &distributor{
-		semaphore: make(chan chan result),
-	}
+	return &distributor{done: make(chan struct{})}
 }
 
+// Distribute stores the result and wakes every caller blocked in Receive,
+// as well as every caller that calls Receive afterwards. It must be called
+// exactly once per Distributor.
 func (d *distributor) Distribute(response *dns.Msg, err error) {
-	for receive := range d.semaphore {
-		value := result{
-			response: response.Copy(),
-			err:      err,
-		}
-		receive <- value
-	}
+	d.result = result{response: response.Copy(), err: err}
+	close(d.done)
 }
 
 func (d *distributor) Receive() (response *dns.Msg, err error) {
-	receive := make(chan result)
-	d.semaphore <- receive
-	value := <-receive
-	return value.response, value.err
+	<-d.done
+	return d.result.response, d.result.err
 }
@@ -0,0 +1,233 @@
+// Package cache implements a querier that caches responses from an
+// upstream querier, coalescing concurrent identical queries into a single
+// upstream exchange.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	"github.com/loadimpact/resolvent/internal"
+	"github.com/loadimpact/resolvent/querier/network"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxNegativeTTL is the ceiling RFC 2308 recommends for negative
+// (NXDOMAIN/NODATA) responses, used when New is given a zero maxNegativeTTL.
+const defaultMaxNegativeTTL = 3 * time.Hour
+
+type cacheEntry struct {
+	response *dns.Msg
+	expires  time.Time
+}
+
+type cachingQuerier struct {
+	upstream       resolvent.Querier
+	maxNegativeTTL time.Duration
+	limiter        internal.SemaphoreMap
+
+	lock     sync.Mutex
+	entries  map[string]cacheEntry
+	inFlight map[string]internal.Distributor
+}
+
+// New returns a querier that caches upstream's responses, keyed by
+// (protocol, address, port, qname, qclass, qtype), honouring per-record TTL
+// (the minimum TTL across the answer RRset) and RFC 2308 negative caching
+// (the SOA MINIMUM for NXDOMAIN/NODATA responses, capped at maxNegativeTTL;
+// a zero maxNegativeTTL uses defaultMaxNegativeTTL). maxConcurrentPerServer
+// bounds, via internal.SemaphoreMap, how many upstream queries may be in
+// flight at once for a given (address, port) pair; concurrent callers
+// asking for the same cache key beyond that never reach upstream at all -
+// they share the single in-flight query via internal.Distributor.
+func New(
+	upstream resolvent.Querier, maxConcurrentPerServer uint16, maxNegativeTTL time.Duration,
+) (*cachingQuerier, error) {
+	if upstream == nil {
+		return nil, errors.New("missing upstream querier")
+	}
+	if maxNegativeTTL <= 0 {
+		maxNegativeTTL = defaultMaxNegativeTTL
+	}
+	return &cachingQuerier{
+		upstream:       upstream,
+		maxNegativeTTL: maxNegativeTTL,
+		limiter:        internal.NewSemaphoreMap(maxConcurrentPerServer),
+		entries:        make(map[string]cacheEntry),
+		inFlight:       make(map[string]internal.Distributor),
+	}, nil
+}
+
+// Query returns a cached response when one is still fresh, otherwise it
+// queries upstream - sharing that upstream query with any other caller
+// asking for the same key at the same time.
+func (q *cachingQuerier) Query(
+	ctx context.Context,
+	protocol resolvent.Protocol,
+	local net.IP,
+	address net.IP,
+	port uint16,
+	qname string,
+	qclass uint16,
+	qtype uint16,
+) (response *dns.Msg, duration time.Duration, err error) {
+	key := cacheKey(protocol, address, port, qname, qclass, qtype)
+
+	if response, ok := q.fresh(key); ok {
+		return response, 0, nil
+	}
+
+	dist, leader := q.joinOrLead(key)
+	if !leader {
+		response, err = dist.Receive()
+		return response, 0, err
+	}
+
+	serverKey, err := internal.ConstructHostport(address, port)
+	if protocol == resolvent.DoH {
+		// DoH queries carry no nameserver address/port - the wrapped
+		// querier always talks to its one configured URL - so they all
+		// share a single limiter key.
+		serverKey, err = protocol.String(), nil
+	}
+	if err != nil {
+		q.abandon(key)
+		dist.Distribute(new(dns.Msg), err)
+		return nil, 0, err
+	}
+
+	if err = q.limiter.Procure(ctx, serverKey); err != nil {
+		q.abandon(key)
+		dist.Distribute(new(dns.Msg), err)
+		return nil, 0, err
+	}
+	response, duration, err = q.upstream.Query(ctx, protocol, local, address, port, qname, qclass, qtype)
+	q.limiter.Vacate(serverKey)
+
+	q.resolve(key, response, err)
+
+	if response == nil {
+		response = new(dns.Msg)
+	}
+	dist.Distribute(response, err)
+	return response, duration, err
+}
+
+// QueryServers extends Query's per-key caching and singleflight
+// coalescing across a list of candidate servers, trying them according to
+// strategy and reporting whichever one ultimately answered. Every
+// candidate still goes through Query, so - unlike the network querier's
+// own QueryServers - a Server's TLS override and a per-call edns have no
+// effect here: Query bakes in whatever TLS/EDNS configuration the wrapped
+// querier was constructed with. ResolverConfig only ever configures one
+// TLS/EDNS setting for every nameserver anyway, so this isn't a real
+// restriction in practice.
+func (q *cachingQuerier) QueryServers(
+	ctx context.Context,
+	servers []network.Server,
+	strategy network.Strategy,
+	perServerTimeout time.Duration,
+	edns network.EDNSOptions,
+	qname string,
+	qclass uint16,
+	qtype uint16,
+) (response *dns.Msg, duration time.Duration, used network.Server, err error) {
+	return network.RunStrategy(ctx, servers, strategy, perServerTimeout, func(ctx context.Context, server network.Server) (*dns.Msg, time.Duration, error) {
+		return q.Query(ctx, server.Protocol, server.Local, server.Remote, server.Port, qname, qclass, qtype)
+	})
+}
+
+func (q *cachingQuerier) fresh(key string) (*dns.Msg, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	entry, ok := q.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(q.entries, key)
+		return nil, false
+	}
+	return entry.response.Copy(), true
+}
+
+// joinOrLead reports whether the caller is the first one asking for key:
+// the leader performs the upstream query and is responsible for calling
+// Distribute; everyone else just Receive()s the leader's result.
+func (q *cachingQuerier) joinOrLead(key string) (dist internal.Distributor, leader bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if dist, ok := q.inFlight[key]; ok {
+		return dist, false
+	}
+	dist = internal.NewDistributor()
+	q.inFlight[key] = dist
+	return dist, true
+}
+
+func (q *cachingQuerier) abandon(key string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.inFlight, key)
+}
+
+// resolve stores a successful response (under its computed TTL) and, in
+// any case, removes key from the in-flight map so that later callers try
+// upstream again.
+func (q *cachingQuerier) resolve(key string, response *dns.Msg, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.inFlight, key)
+	if err != nil || response == nil {
+		return
+	}
+	if ttl := cacheableTTL(response, q.maxNegativeTTL); ttl > 0 {
+		q.entries[key] = cacheEntry{
+			response: response.Copy(),
+			expires:  time.Now().Add(ttl),
+		}
+	}
+}
+
+// cacheableTTL returns how long response may be cached: the minimum TTL
+// across the answer RRset for a successful response, or the RFC 2308
+// negative-caching TTL (the authority section's SOA MINIMUM, capped at
+// maxNegativeTTL) for NXDOMAIN/NODATA. It returns 0 when response carries
+// nothing cacheable.
+func cacheableTTL(response *dns.Msg, maxNegativeTTL time.Duration) time.Duration {
+	if response.Rcode == dns.RcodeSuccess && len(response.Answer) > 0 {
+		min := response.Answer[0].Header().Ttl
+		for _, rr := range response.Answer[1:] {
+			if ttl := rr.Header().Ttl; ttl < min {
+				min = ttl
+			}
+		}
+		return time.Duration(min) * time.Second
+	}
+
+	for _, rr := range response.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := time.Duration(soa.Minimum) * time.Second
+			if ttl > maxNegativeTTL {
+				ttl = maxNegativeTTL
+			}
+			return ttl
+		}
+	}
+	return 0
+}
+
+func cacheKey(
+	protocol resolvent.Protocol, address net.IP, port uint16, qname string, qclass uint16, qtype uint16,
+) string {
+	return fmt.Sprintf(
+		"%d|%s|%d|%s|%d|%d",
+		protocol, address, port, strings.ToLower(dns.Fqdn(qname)), qclass, qtype,
+	)
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	"github.com/miekg/dns"
+)
+
+// stubQuerier is a resolvent.Querier that counts how many times it was
+// called and always answers with a single A record.
+type stubQuerier struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (q *stubQuerier) Query(
+	ctx context.Context, protocol resolvent.Protocol, local, address net.IP, port uint16,
+	qname string, qclass, qtype uint16,
+) (*dns.Msg, time.Duration, error) {
+	q.mu.Lock()
+	q.calls++
+	q.mu.Unlock()
+
+	response := new(dns.Msg)
+	response.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(qname), Rrtype: dns.TypeA, Class: qclass, Ttl: 60},
+		A:   net.ParseIP("203.0.113.1"),
+	}}
+	return response, 5 * time.Millisecond, nil
+}
+
+func (q *stubQuerier) callCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.calls
+}
+
+// TestQueryCoalescesConcurrentCallers exercises the singleflight path: every
+// concurrent caller for the same key must get an answer, and the upstream
+// querier must only be hit once. Before the Distributor fix this deadlocked
+// every caller indefinitely, since Distribute never returned.
+func TestQueryCoalescesConcurrentCallers(t *testing.T) {
+	upstream := &stubQuerier{}
+	querier, err := New(upstream, 10, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	const followers = 4
+	errs := make(chan error, followers)
+	var wg sync.WaitGroup
+	wg.Add(followers)
+	for i := 0; i < followers; i++ {
+		go func() {
+			defer wg.Done()
+			_, _, queryErr := querier.Query(
+				context.Background(), resolvent.UDP, nil, net.ParseIP("127.0.0.1"), 53,
+				"example.com.", dns.ClassINET, dns.TypeA,
+			)
+			errs <- queryErr
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Query did not return for all concurrent callers - Distributor likely deadlocked")
+	}
+	close(errs)
+
+	for queryErr := range errs {
+		if queryErr != nil {
+			t.Errorf("Query: %v", queryErr)
+		}
+	}
+
+	if got := upstream.callCount(); got != 1 {
+		t.Errorf("upstream called %d times, want exactly 1 (concurrent callers should share the in-flight query)", got)
+	}
+}
+
+// TestQueryServesFromCacheUntilTTLExpires exercises the fresh()/TTL path:
+// a second Query for the same key must be answered from the cache - not by
+// calling upstream again - until the answer's TTL has elapsed.
+func TestQueryServesFromCacheUntilTTLExpires(t *testing.T) {
+	upstream := &stubQuerier{}
+	querier, err := New(upstream, 10, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, _, queryErr := querier.Query(ctx, resolvent.UDP, nil, net.ParseIP("127.0.0.1"), 53, "example.com.", dns.ClassINET, dns.TypeA)
+		if queryErr != nil {
+			t.Fatalf("Query: %v", queryErr)
+		}
+	}
+
+	if got := upstream.callCount(); got != 1 {
+		t.Errorf("upstream called %d times, want exactly 1 (repeat lookups should be served from cache while fresh)", got)
+	}
+}
+
+// nxdomainQuerier always answers with NXDOMAIN and an authority-section SOA,
+// the shape RFC 2308 negative caching keys off.
+type nxdomainQuerier struct {
+	mu    sync.Mutex
+	calls int
+	ttl   uint32
+}
+
+func (q *nxdomainQuerier) Query(
+	ctx context.Context, protocol resolvent.Protocol, local, address net.IP, port uint16,
+	qname string, qclass, qtype uint16,
+) (*dns.Msg, time.Duration, error) {
+	q.mu.Lock()
+	q.calls++
+	q.mu.Unlock()
+
+	response := new(dns.Msg)
+	response.Rcode = dns.RcodeNameError
+	response.Ns = []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(qname), Rrtype: dns.TypeSOA, Class: qclass},
+		Minimum: q.ttl,
+	}}
+	return response, 5 * time.Millisecond, nil
+}
+
+func (q *nxdomainQuerier) callCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.calls
+}
+
+// TestQueryCachesNegativeResponses exercises RFC 2308 negative caching: an
+// NXDOMAIN response must be cached for its SOA MINIMUM (capped at
+// maxNegativeTTL), so a repeat lookup is served from the cache too.
+func TestQueryCachesNegativeResponses(t *testing.T) {
+	upstream := &nxdomainQuerier{ttl: 3600}
+	querier, err := New(upstream, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		response, _, queryErr := querier.Query(ctx, resolvent.UDP, nil, net.ParseIP("127.0.0.1"), 53, "nonexistent.example.", dns.ClassINET, dns.TypeA)
+		if queryErr != nil {
+			t.Fatalf("Query: %v", queryErr)
+		}
+		if response.Rcode != dns.RcodeNameError {
+			t.Fatalf("Rcode = %v, want NXDOMAIN", response.Rcode)
+		}
+	}
+
+	if got := upstream.callCount(); got != 1 {
+		t.Errorf("upstream called %d times, want exactly 1 (NXDOMAIN should be negative-cached per its SOA MINIMUM)", got)
+	}
+}
+
+// TestCacheableTTLCapsNegativeTTL exercises cacheableTTL directly: an SOA
+// MINIMUM above maxNegativeTTL must be capped at maxNegativeTTL, per RFC
+// 2308.
+func TestCacheableTTLCapsNegativeTTL(t *testing.T) {
+	response := new(dns.Msg)
+	response.Rcode = dns.RcodeNameError
+	response.Ns = []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA},
+		Minimum: uint32((2 * time.Hour).Seconds()),
+	}}
+
+	if ttl := cacheableTTL(response, time.Hour); ttl != time.Hour {
+		t.Errorf("cacheableTTL = %s, want capped at maxNegativeTTL (1h)", ttl)
+	}
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const dnsMessageMIMEType = "application/dns-message"
+
+type dohQuerier struct {
+	urlTemplate string
+	httpClient  *http.Client
+}
+
+// NewDoH returns a querier that performs DNS-over-HTTPS (RFC 8484) exchange
+// by POSTing "application/dns-message" bodies to urlTemplate. httpClient is
+// used as-is, so callers that want DoH lookups to share a dialer (and thus
+// its byte counters) with the rest of their traffic should pass one
+// configured with that dialer's DialContext.
+func NewDoH(urlTemplate string, httpClient *http.Client) (querier *dohQuerier, err error) {
+	if urlTemplate == "" {
+		return nil, errors.New("missing DoH endpoint URL")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &dohQuerier{urlTemplate: urlTemplate, httpClient: httpClient}, nil
+}
+
+// Query executes a DNS-over-HTTPS exchange. local, address and port are
+// ignored; the destination is entirely determined by the configured URL.
+func (q *dohQuerier) Query(
+	ctx context.Context,
+	protocol resolvent.Protocol,
+	local net.IP,
+	address net.IP,
+	port uint16,
+	qname string,
+	qclass uint16,
+	qtype uint16,
+) (response *dns.Msg, duration time.Duration, err error) {
+	if protocol != resolvent.DoH {
+		return nil, 0, errors.New("dohQuerier only supports the DoH protocol")
+	}
+
+	request := new(dns.Msg)
+	request.Id = dns.Id()
+	request.Question = make([]dns.Question, 1)
+	request.Question[0] = dns.Question{
+		Name:   dns.Fqdn(qname),
+		Qclass: qclass,
+		Qtype:  qtype,
+	}
+	wire, err := request.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, q.urlTemplate, bytes.NewReader(wire),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	httpRequest.Header.Set("Content-Type", dnsMessageMIMEType)
+	httpRequest.Header.Set("Accept", dnsMessageMIMEType)
+
+	start := time.Now()
+	httpResponse, err := q.httpClient.Do(httpRequest)
+	duration = time.Since(start)
+	if err != nil {
+		return nil, duration, err
+	}
+	defer httpResponse.Body.Close() // nolint:errcheck
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, duration, fmt.Errorf("DoH query failed with status %s", httpResponse.Status)
+	}
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, duration, err
+	}
+
+	response = new(dns.Msg)
+	if err = response.Unpack(body); err != nil {
+		return nil, duration, err
+	}
+	return response, duration, nil
+}
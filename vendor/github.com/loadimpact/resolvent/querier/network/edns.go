@@ -0,0 +1,81 @@
+package network
+
+import (
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// defaultEDNSUDPSize is the UDP payload size advertised in the OPT record,
+// per the DNS Flag Day 2020 recommendation.
+const defaultEDNSUDPSize = 1232
+
+// EDNSOptions configures the OPT pseudo-RR a networkQuerier attaches to
+// every query it sends; see New.
+type EDNSOptions struct {
+	// UDPSize is the advertised UDP payload size; 0 uses defaultEDNSUDPSize.
+	UDPSize uint16
+	// DNSSEC sets the DO bit, requesting DNSSEC records in the response.
+	DNSSEC bool
+	// ClientSubnet, if non-nil, sends an EDNS Client Subnet (RFC 7871)
+	// option scoped to this network - it is opt-in because it leaks
+	// client network information to the nameserver.
+	ClientSubnet *net.IPNet
+	// Cookie, if non-empty, sends an RFC 7873 client cookie, letting a
+	// cooperating nameserver verify the response wasn't spoofed.
+	Cookie []byte
+}
+
+// buildRequest constructs the outgoing query for qname, attaching an OPT
+// pseudo-RR per edns - always advertising a UDP buffer size, and adding
+// the DO bit, EDNS Client Subnet or cookie options only when edns asks for
+// them.
+func buildRequest(qname string, qclass, qtype uint16, edns EDNSOptions) *dns.Msg {
+	request := new(dns.Msg)
+	request.Id = dns.Id()
+	request.Question = []dns.Question{{
+		Name:   dns.Fqdn(qname),
+		Qclass: qclass,
+		Qtype:  qtype,
+	}}
+
+	udpSize := edns.UDPSize
+	if udpSize == 0 {
+		udpSize = defaultEDNSUDPSize
+	}
+
+	opt := new(dns.OPT)
+	opt.Hdr.Name = "."
+	opt.Hdr.Rrtype = dns.TypeOPT
+	opt.SetUDPSize(udpSize)
+	if edns.DNSSEC {
+		opt.SetDo()
+	}
+
+	if edns.ClientSubnet != nil {
+		ones, _ := edns.ClientSubnet.Mask.Size()
+		subnet := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			SourceNetmask: uint8(ones),
+		}
+		if ip4 := edns.ClientSubnet.IP.To4(); ip4 != nil {
+			subnet.Family = 1
+			subnet.Address = ip4
+		} else {
+			subnet.Family = 2
+			subnet.Address = edns.ClientSubnet.IP
+		}
+		opt.Option = append(opt.Option, subnet)
+	}
+
+	if len(edns.Cookie) > 0 {
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+			Code:   dns.EDNS0COOKIE,
+			Cookie: hex.EncodeToString(edns.Cookie),
+		})
+	}
+
+	request.Extra = append(request.Extra, opt)
+	return request
+}
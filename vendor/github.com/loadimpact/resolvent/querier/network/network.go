@@ -3,6 +3,7 @@ package network
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -14,21 +15,30 @@ import (
 
 type networkQuerier struct {
 	clients map[string]map[resolvent.Protocol]*dns.Client
+	edns    EDNSOptions
 }
 
-// New returns a querier that performs network exchange.
-func New() (querier *networkQuerier, err error) {
-	clients, err := constructClients()
+// New returns a querier that performs network exchange over UDP, TCP and
+// DoT (DNS-over-TLS). tlsConfig is used as the
+// base configuration (SNI, pinned certificates, ...) for every DoT client;
+// pass nil to get the Go default TLS behaviour. edns is attached to every
+// outgoing query via buildRequest - pass EDNSOptions{} for just the
+// default OPT record.
+func New(tlsConfig *tls.Config, edns EDNSOptions) (querier *networkQuerier, err error) {
+	clients, err := constructClients(tlsConfig)
 	if err != nil {
 		return
 	}
 	querier = &networkQuerier{
 		clients: clients,
+		edns:    edns,
 	}
 	return
 }
 
-// Query executes an exchange with a single DNS nameserver.
+// Query executes an exchange with a single DNS nameserver, attaching the
+// querier's EDNS0 OPT record (see buildRequest). A UDP response that comes
+// back with TC=1 set is transparently retried over TCP.
 func (q *networkQuerier) Query(
 	ctx context.Context,
 	protocol resolvent.Protocol,
@@ -39,7 +49,7 @@ func (q *networkQuerier) Query(
 	qclass uint16,
 	qtype uint16,
 ) (response *dns.Msg, duration time.Duration, err error) {
-	client, err := q.acquireClient(protocol, local)
+	client, err := q.acquireClient(protocol, local, nil)
 	if err != nil {
 		return
 	}
@@ -47,20 +57,35 @@ func (q *networkQuerier) Query(
 	if err != nil {
 		return
 	}
-	request := new(dns.Msg)
-	request.Id = dns.Id()
-	request.Question = make([]dns.Question, 1)
-	request.Question[0] = dns.Question{
-		Name:   dns.Fqdn(qname),
-		Qclass: qclass,
-		Qtype:  qtype,
+
+	request := buildRequest(qname, qclass, qtype, q.edns)
+	response, duration, err = client.ExchangeContext(ctx, request, hostport)
+	if err != nil {
+		return
+	}
+
+	if response.Truncated && protocol == resolvent.UDP {
+		var tcpClient *dns.Client
+		tcpClient, err = q.acquireClient(resolvent.TCP, local, nil)
+		if err != nil {
+			return
+		}
+		tcpResponse, tcpDuration, tcpErr := tcpClient.ExchangeContext(ctx, request, hostport)
+		if tcpErr != nil {
+			return response, duration, tcpErr
+		}
+		return tcpResponse, duration + tcpDuration, nil
 	}
-	return client.ExchangeContext(ctx, request, hostport)
+	return response, duration, nil
 }
 
+// acquireClient returns the pre-built client for protocol/local, unless
+// tlsOverride is non-nil and protocol is DoT, in which case a copy of that
+// client is returned with TLSConfig swapped for tlsOverride - leaving the
+// querier's own clients (and any other caller using them concurrently)
+// untouched.
 func (q *networkQuerier) acquireClient(
-	protocol resolvent.Protocol,
-	local net.IP,
+	protocol resolvent.Protocol, local net.IP, tlsOverride *tls.Config,
 ) (client *dns.Client, err error) {
 	addressClients, ok := q.clients[local.String()]
 	if !ok {
@@ -72,5 +97,10 @@ func (q *networkQuerier) acquireClient(
 		err = errors.New("invalid protocol")
 		return
 	}
+	if protocol == resolvent.DoT && tlsOverride != nil {
+		override := *client
+		override.TLSConfig = tlsOverride
+		client = &override
+	}
 	return
 }
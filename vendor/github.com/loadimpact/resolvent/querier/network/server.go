@@ -0,0 +1,184 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/loadimpact/resolvent"
+	"github.com/loadimpact/resolvent/internal"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Server is a single nameserver to query: its transport, local address,
+// remote address/port, and (for DoT) the TLS configuration to dial it
+// with.
+type Server struct {
+	Protocol resolvent.Protocol
+	Local    net.IP
+	Remote   net.IP
+	Port     uint16
+	TLS      *tls.Config
+}
+
+// Strategy selects how QueryServers iterates a Server list.
+type Strategy int
+
+const (
+	// Sequential tries servers one at a time, in the order given, stopping
+	// at the first successful exchange.
+	Sequential Strategy = iota
+	// Random tries servers one at a time, in a randomised order.
+	Random
+	// ParallelFirstResponse queries every server at once and returns
+	// whichever response arrives first.
+	ParallelFirstResponse
+)
+
+// QueryServers queries servers according to strategy, deriving each
+// server's own timeout from perServerTimeout (typically resolv.conf's
+// "timeout" option). On UDP responses with TC=1 set, it transparently
+// retries the same server over TCP before giving up on it. Alongside the
+// response, it reports which Server ultimately answered - or, on total
+// failure, the last one attempted - so callers that tag per-server metrics
+// don't need to re-derive it.
+func (q *networkQuerier) QueryServers(
+	ctx context.Context,
+	servers []Server,
+	strategy Strategy,
+	perServerTimeout time.Duration,
+	edns EDNSOptions,
+	qname string,
+	qclass uint16,
+	qtype uint16,
+) (response *dns.Msg, duration time.Duration, used Server, err error) {
+	return RunStrategy(ctx, servers, strategy, perServerTimeout, func(ctx context.Context, server Server) (*dns.Msg, time.Duration, error) {
+		return q.queryOne(ctx, server, edns, qname, qclass, qtype)
+	})
+}
+
+// queryOne performs a single exchange with server, honouring its own TLS
+// override instead of the querier's default, retrying over TCP if a UDP
+// response comes back truncated.
+func (q *networkQuerier) queryOne(
+	ctx context.Context, server Server, edns EDNSOptions, qname string, qclass, qtype uint16,
+) (*dns.Msg, time.Duration, error) {
+	client, err := q.acquireClient(server.Protocol, server.Local, server.TLS)
+	if err != nil {
+		return nil, 0, err
+	}
+	hostport, err := internal.ConstructHostport(server.Remote, server.Port)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request := buildRequest(qname, qclass, qtype, edns)
+	response, duration, err := client.ExchangeContext(ctx, request, hostport)
+	if err != nil {
+		return nil, duration, err
+	}
+
+	if response.Truncated && server.Protocol == resolvent.UDP {
+		tcpClient, tcpErr := q.acquireClient(resolvent.TCP, server.Local, server.TLS)
+		if tcpErr != nil {
+			return response, duration, tcpErr
+		}
+		tcpResponse, tcpDuration, tcpErr := tcpClient.ExchangeContext(ctx, request, hostport)
+		if tcpErr != nil {
+			return response, duration, tcpErr
+		}
+		return tcpResponse, duration + tcpDuration, nil
+	}
+	return response, duration, nil
+}
+
+// RunStrategy queries servers according to strategy, using query to
+// perform a single exchange with one server (already bounded by
+// perServerTimeout, applied here so every caller gets it for free). It
+// factors out the sequential/random/parallel-first-response iteration that
+// both networkQuerier.QueryServers and the caching querier's own
+// QueryServers build on, including which Server ultimately answered.
+func RunStrategy(
+	ctx context.Context,
+	servers []Server,
+	strategy Strategy,
+	perServerTimeout time.Duration,
+	query func(ctx context.Context, server Server) (*dns.Msg, time.Duration, error),
+) (response *dns.Msg, duration time.Duration, used Server, err error) {
+	if len(servers) == 0 {
+		return nil, 0, Server{}, errors.New("no servers to query")
+	}
+
+	queryOne := func(ctx context.Context, server Server) (*dns.Msg, time.Duration, error) {
+		if perServerTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, perServerTimeout)
+			defer cancel()
+		}
+		return query(ctx, server)
+	}
+
+	if strategy == ParallelFirstResponse {
+		return runParallel(ctx, servers, queryOne)
+	}
+
+	order := servers
+	if strategy == Random {
+		order = make([]Server, len(servers))
+		copy(order, servers)
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	var lastErr error
+	for _, server := range order {
+		resp, dur, qErr := queryOne(ctx, server)
+		if qErr != nil {
+			lastErr = qErr
+			used = server
+			continue
+		}
+		return resp, dur, server, nil
+	}
+	return nil, 0, used, lastErr
+}
+
+func runParallel(
+	ctx context.Context,
+	servers []Server,
+	queryOne func(context.Context, Server) (*dns.Msg, time.Duration, error),
+) (response *dns.Msg, duration time.Duration, used Server, err error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		server   Server
+		response *dns.Msg
+		duration time.Duration
+		err      error
+	}
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		server := server
+		go func() {
+			resp, dur, qErr := queryOne(raceCtx, server)
+			results <- result{server, resp, dur, qErr}
+		}()
+	}
+
+	var lastErr error
+	var lastServer Server
+	for range servers {
+		res := <-results
+		if res.err != nil {
+			lastErr = res.err
+			lastServer = res.server
+			continue
+		}
+		cancel()
+		return res.response, res.duration, res.server, nil
+	}
+	return nil, 0, lastServer, lastErr
+}
@@ -1,18 +1,21 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 
 	"github.com/loadimpact/resolvent"
 	"github.com/miekg/dns"
 )
 
-func constructClients() (
+func constructClients(
+	tlsConfig *tls.Config,
+) (
 	clients map[string]map[resolvent.Protocol]*dns.Client,
 	err error,
 ) {
 	clients = make(map[string]map[resolvent.Protocol]*dns.Client)
-	clients[net.IPv4zero.String()] = constructDefaultAddressClients()
+	clients[net.IPv4zero.String()] = constructDefaultAddressClients(tlsConfig)
 	clients[net.IPv6zero.String()] = clients[net.IPv4zero.String()]
 	addresses, err := net.InterfaceAddrs()
 	if err != nil {
@@ -24,12 +27,12 @@ func constructClients() (
 		if err != nil {
 			return
 		}
-		clients[ip.String()] = constructAddressClients(ip)
+		clients[ip.String()] = constructAddressClients(ip, tlsConfig)
 	}
 	return
 }
 
-func constructDefaultAddressClients() map[resolvent.Protocol]*dns.Client {
+func constructDefaultAddressClients(tlsConfig *tls.Config) map[resolvent.Protocol]*dns.Client {
 	clients := make(map[resolvent.Protocol]*dns.Client)
 	clients[resolvent.UDP] = &dns.Client{
 		Net: "udp",
@@ -37,11 +40,16 @@ func constructDefaultAddressClients() map[resolvent.Protocol]*dns.Client {
 	clients[resolvent.TCP] = &dns.Client{
 		Net: "tcp",
 	}
+	clients[resolvent.DoT] = &dns.Client{
+		Net:       "tcp-tls",
+		TLSConfig: cloneTLSConfig(tlsConfig),
+	}
 	return clients
 }
 
 func constructAddressClients(
 	address net.IP,
+	tlsConfig *tls.Config,
 ) (clients map[resolvent.Protocol]*dns.Client) {
 	clients = make(map[resolvent.Protocol]*dns.Client)
 	clients[resolvent.UDP] = &dns.Client{
@@ -60,5 +68,25 @@ func constructAddressClients(
 			},
 		},
 	}
+	clients[resolvent.DoT] = &dns.Client{
+		Net: "tcp-tls",
+		Dialer: &net.Dialer{
+			LocalAddr: &net.TCPAddr{
+				IP: address,
+			},
+		},
+		TLSConfig: cloneTLSConfig(tlsConfig),
+	}
 	return
 }
+
+// cloneTLSConfig returns a copy of tlsConfig so that each constructed
+// dns.Client owns a distinct *tls.Config, safe for later per-client
+// mutation (e.g. setting ServerName). A nil tlsConfig yields an empty one,
+// so DoT is always usable even when the caller has not pinned anything.
+func cloneTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		return &tls.Config{}
+	}
+	return tlsConfig.Clone()
+}
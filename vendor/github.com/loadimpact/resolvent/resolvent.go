@@ -15,8 +15,29 @@ type Protocol int
 const (
 	UDP Protocol = iota
 	TCP
+	// DoT is DNS-over-TLS, as described in RFC 7858.
+	DoT
+	// DoH is DNS-over-HTTPS, as described in RFC 8484.
+	DoH
 )
 
+// String returns the lower-case transport name, as used in metric tags and
+// log messages: "udp", "tcp", "dot" or "doh".
+func (p Protocol) String() string {
+	switch p {
+	case UDP:
+		return "udp"
+	case TCP:
+		return "tcp"
+	case DoT:
+		return "dot"
+	case DoH:
+		return "doh"
+	default:
+		return "unknown"
+	}
+}
+
 // Querier is the interface implemented by DNS queriers.
 type Querier interface {
 	Query(